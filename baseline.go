@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// loadBaseline reads a results file written with -results-file and returns the
+// ns/op per phase of the runs that match the map type, the key and value
+// types, the dataset size and GOMAXPROCS; runs recorded without the types or
+// without GOMAXPROCS, by older versions, match any value. When several runs
+// match, the most recent one wins for each phase.
+func loadBaseline(path, mapType, keyType, valueType string, size uint64, procs int) (map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	baseline := make(map[string]float64)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec resultRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if rec.MapType != mapType || rec.Size != size || (rec.Procs != 0 && rec.Procs != procs) {
+			continue
+		}
+		if (rec.KeyType != "" && rec.KeyType != keyType) || (rec.ValueType != "" && rec.ValueType != valueType) {
+			continue
+		}
+		for phase, ns := range rec.NsPerOp {
+			baseline[phase] = ns
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return baseline, nil
+}
+
+// compareBaseline prints the change of ns/op of every result that has a
+// baseline and reports whether none of them regressed by more than threshold
+// percent.
+func compareBaseline(baseline map[string]float64, results []Result, threshold float64) bool {
+	fmt.Println("Baseline comparison:")
+	ok := true
+	for _, r := range results {
+		base, found := baseline[r.Phase]
+		if !found || base <= 0 {
+			fmt.Printf("  %s: no baseline\n", r.Phase)
+			continue
+		}
+		ns := nsPerOp(r.BenchmarkResult)
+		change := (ns - base) / base * 100
+		verdict := ""
+		if change > threshold {
+			verdict = " REGRESSION"
+			ok = false
+		}
+		fmt.Printf("  %s: %.2f -> %.2f ns/op (%+.2f%%)%s\n", r.Phase, base, ns, change, verdict)
+	}
+	return ok
+}
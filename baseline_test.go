@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBaselineMatchesTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	lines := `{"map_type":"crn4","size":100,"gomaxprocs":4,"ns_per_op":{"Insert":10,"Lookup":1}}
+{"map_type":"crn4","key_type":"int","value_type":"int","size":100,"gomaxprocs":4,"ns_per_op":{"Insert":20}}
+{"map_type":"crn4","key_type":"string","value_type":"int","size":100,"gomaxprocs":4,"ns_per_op":{"Insert":30}}
+{"map_type":"crn4","key_type":"int","value_type":"struct256","size":100,"gomaxprocs":4,"ns_per_op":{"Insert":40}}
+`
+	if err := os.WriteFile(path, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		keyType, valueType string
+		insert, lookup     float64
+	}{
+		{"int", "int", 20, 1},
+		{"string", "int", 30, 1},
+		{"int", "struct256", 40, 1},
+		{"float64", "string", 10, 1},
+	}
+	for _, tt := range tests {
+		baseline, err := loadBaseline(path, "crn4", tt.keyType, tt.valueType, 100, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if baseline["Insert"] != tt.insert || baseline["Lookup"] != tt.lookup {
+			t.Errorf("%s/%s: baseline = %v; want Insert %v, Lookup %v", tt.keyType, tt.valueType, baseline, tt.insert, tt.lookup)
+		}
+	}
+}
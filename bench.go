@@ -2,37 +2,121 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"runtime"
+	"slices"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 
+	crn4 "github.com/crn4/swiss"
+	crn4hash "github.com/crn4/swiss/hash"
 	"pgregory.net/rand"
 )
 
-func randT[T any](r1 *rand.Rand) T {
+// randT returns a random T. Strings, including string struct fields, are
+// strLen characters long.
+func randT[T any](r1 *rand.Rand, strLen int) T {
 	t := reflect.TypeOf((*T)(nil)).Elem()
 
 	switch t.Kind() {
 	case reflect.Int:
-		v := rand.Int()
+		v := r1.Int()
+		return any(v).(T)
+	case reflect.Uint64:
+		v := r1.Uint64()
+		return any(v).(T)
+	case reflect.Uint32:
+		v := r1.Uint32()
 		return any(v).(T)
 	case reflect.Float64:
-		v := rand.Float64()
+		// Floats are in [0, 1), so they are never NaN, which as a key could
+		// never be found again, nor Inf. See Options.AllowNaN.
+		v := r1.Float64()
+		return any(v).(T)
+	case reflect.Float32:
+		v := r1.Float32()
 		return any(v).(T)
 	case reflect.String:
-		v := randString(r1, 7)
+		v := randString(r1, strLen)
 		return any(v).(T)
+	case reflect.Pointer:
+		// Every call allocates a new pointee, so pointers are always distinct
+		// keys, even when they point to equal values.
+		v := reflect.New(t.Elem())
+		v.Elem().Set(randField(r1, t.Elem(), strLen))
+		return v.Interface().(T)
 	case reflect.Struct:
-		if t.NumField() == 0 {
-			var v T
-			return v
+		v := reflect.New(t).Elem()
+		for i := range t.NumField() {
+			if f := v.Field(i); f.CanSet() {
+				f.Set(randField(r1, f.Type(), strLen))
+			}
 		}
-		panic("only empty structs are supported")
+		return v.Interface().(T)
 	default:
 		panic("unsupported type")
 	}
 }
 
+const (
+	clusterRun = 64
+	clusterGap = 4096
+)
+
+// seqT converts the n-th element of a sequence into T. Integer keys are used
+// as is and string keys are zero-padded decimal counters.
+func seqT[T any](n uint64) T {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	switch t.Kind() {
+	case reflect.Int:
+		return any(int(n)).(T)
+	case reflect.Uint64:
+		return any(n).(T)
+	case reflect.Uint32:
+		return any(uint32(n)).(T)
+	case reflect.String:
+		return any(fmt.Sprintf("%07d", n)).(T)
+	default:
+		panic("only integer and string keys support sequential distributions")
+	}
+}
+
+// keyAt returns the i-th dataset key for the "sequential" and "clustered"
+// key distributions. Clustered keys come in dense runs of clusterRun
+// consecutive values separated by gaps of clusterGap values.
+func keyAt[K any](dist string, i uint64) K {
+	if dist == "clustered" {
+		return seqT[K](i/clusterRun*(clusterRun+clusterGap) + i%clusterRun)
+	}
+	return seqT[K](i)
+}
+
+func randField(r *rand.Rand, t reflect.Type, strLen int) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int:
+		v.SetInt(int64(r.Int()))
+	case reflect.Uint64:
+		v.SetUint(r.Uint64())
+	case reflect.Float64:
+		v.SetFloat(r.Float64())
+	case reflect.String:
+		v.SetString(randString(r, strLen))
+	case reflect.Array:
+		if t.Elem().Kind() != reflect.Uint8 {
+			panic("unsupported struct field type")
+		}
+		r.Read(v.Bytes())
+	default:
+		panic("unsupported struct field type")
+	}
+	return v
+}
+
 func randString(r *rand.Rand, length int) string {
 	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, length)
@@ -45,28 +129,281 @@ func randString(r *rand.Rand, length int) string {
 
 type Map[K comparable, V any] interface {
 	Get(K) (V, bool)
+	Contains(K) bool
 	Set(K, V)
 	Delete(K)
+	Iterate(func(K, V) bool)
+	Len() int
+	Clear()
+}
+
+// ProbeStater is implemented by maps that can report probe length
+// statistics for their current contents.
+type ProbeStater interface {
+	ProbeStats() (avg float64, max int)
+}
+
+// Options enables optional benchmark phases.
+type Options struct {
+	// Parallel enables the concurrent read-only lookup phase.
+	Parallel bool
+	// Histogram enables printing the probe length histogram.
+	Histogram bool
+	// Latency enables the lookup latency percentiles phase.
+	Latency bool
+	// Distribution selects how the Lookup phase picks keys: "uniform" walks
+	// the dataset in order, "zipf" draws keys from a Zipf distribution so
+	// that a few keys are looked up most of the time.
+	Distribution string
+	// ZipfS is the exponent of the Zipf distribution, which must be > 1.
+	ZipfS float64
+	// KeyDist selects how dataset keys are generated: "random", "sequential"
+	// or "clustered", or "colliding" to pile keys up in crn4 map groups.
+	KeyDist string
+	// HitRatio enables the mixed lookup phase when it is not negative. It is
+	// the fraction of looked up keys that are present in the map.
+	HitRatio float64
+	// Runs is the number of times every timed phase is repeated. The median
+	// run is reported along with the spread of all of them.
+	Runs int
+	// Phases holds the names, as listed in Phases and Reports, of the phases
+	// to run. All of them run if it is nil.
+	Phases map[string]bool
+	// GrowthMilestones enables the growth curve phase when not empty. It
+	// holds the increasing entry counts at which the curve is sampled.
+	GrowthMilestones []int
+	// AllowNaN replaces every nanEvery-th float key with NaN. A NaN key is
+	// never equal to itself, so the maps can't find it and every insertion
+	// of it adds an entry.
+	AllowNaN bool
+	// KeyLen and ValueLen are the lengths of random string keys and values.
+	KeyLen, ValueLen int
+	// SizeHint is the size hint the maps are created with.
+	SizeHint int
+	// Hash names the hash function of the cocroach and crn4 maps, "runtime"
+	// or "xxhash".
+	Hash string
+	// Pow2Groups makes the crn4 map round its number of groups up to a power
+	// of two and mask h1 instead of dividing it.
+	Pow2Groups bool
+	// Reuse makes the Insert phase clear and refill a single map instead of
+	// creating a new one on every iteration.
+	Reuse bool
+	// DupRatio is the fraction, in the range [0, 1), of dataset keys that
+	// repeat an earlier key, so that Insert also updates existing entries.
+	DupRatio float64
+}
+
+// ProbeHistogrammer is implemented by maps that can report the distribution
+// of probe lengths for their current contents.
+type ProbeHistogrammer interface {
+	ProbeHistogram() []int
+}
+
+// Sized is implemented by maps that can report their capacity, the number of
+// entries they hold before resizing, along with their number of entries.
+type Sized interface {
+	Len() int
+	Cap() int
+}
+
+// Rehasher is implemented by maps that count their own resizes.
+type Rehasher interface {
+	Rehashes() int
+}
+
+// Tombstoner is implemented by maps that can report the number of deleted
+// slots they still hold.
+type Tombstoner interface {
+	Tombstones() int
 }
 
 type Bench[K comparable, V any] struct {
 	m      func() Map[K, V]
+	opts   Options
+	seed   uint64
 	keys   []K
 	values []V
+	// unique holds the distinct keys, in the order of their first
+	// occurrence in keys. It is keys itself if Options.DupRatio is 0.
+	unique []K
+	// misses holds keys that are absent from keys.
+	misses []K
+	// lookups holds the indexes of keys looked up by the Lookup phase. It is
+	// nil for the uniform distribution.
+	lookups []int
+}
+
+// collidingSpread is the stride between the crn4 groups colliding keys hash
+// to, and collidingAttempts bounds the random draws made to find each key.
+const (
+	collidingSpread   = 4
+	collidingAttempts = 64
+)
+
+// collidingKeys returns a generator of random keys that the crn4 map of the
+// harness hashes only to every collidingSpread-th group once it holds n keys.
+// Each of those groups receives the keys of collidingSpread groups and spills
+// them into its neighbors, so crn4 has to probe chains of full groups. The
+// layout is computed for the harness seed, hash, size hint and group sizing,
+// by filling a throwaway map to find its final number of groups. The other
+// maps hash differently and see the keys as random. If no matching key is
+// found within collidingAttempts draws, e.g. for struct{} keys, a random key
+// is returned.
+func collidingKeys[K comparable](r *rand.Rand, n, sizeHint, keyLen int, hash string, pow2 bool, seed uint64) func() K {
+	probe := crn4.NewWithSeed[int, struct{}](sizeHint, 0)
+	if pow2 {
+		probe = crn4.NewWithPow2Groups[int, struct{}](sizeHint, crn4hash.GetHashFunc[int](), 0)
+	}
+	for i := range n {
+		probe.Put(i, struct{}{})
+	}
+	groups := uint32(probe.Stats().Groups)
+	hashfn := crn4hash.GetHashFunc[K]()
+	if hash == "xxhash" {
+		hashfn = crn4hash.GetHashFuncXX[K]()
+	}
+
+	return func() K {
+		var key K
+		for range collidingAttempts {
+			key = randT[K](r, keyLen)
+			// Mirrors how crn4 picks the first group to probe.
+			h1 := uint32(hashfn(unsafe.Pointer(&key), uintptr(seed)) >> 7)
+			if h1%groups%collidingSpread == 0 {
+				break
+			}
+		}
+		return key
+	}
 }
 
-func New[K comparable, V any](size, seed uint64, m func() Map[K, V]) Bench[K, V] {
-	b := Bench[K, V]{m: m, keys: make([]K, size), values: make([]V, size)}
+func New[K comparable, V any](size, seed uint64, m func() Map[K, V], opts Options) Bench[K, V] {
+	b := Bench[K, V]{m: m, opts: opts, seed: seed, keys: make([]K, size), values: make([]V, size)}
 	r := rand.New(seed)
+	var colliding func() K
+	if opts.KeyDist == "colliding" {
+		colliding = collidingKeys[K](r, int(size), opts.SizeHint, opts.KeyLen, opts.Hash, opts.Pow2Groups, seed)
+	}
 	for i := range size {
-		b.keys[i] = randT[K](r)
-		b.values[i] = randT[V](r)
+		switch opts.KeyDist {
+		case "random":
+			b.keys[i] = randT[K](r, opts.KeyLen)
+		case "colliding":
+			b.keys[i] = colliding()
+		default:
+			b.keys[i] = keyAt[K](opts.KeyDist, i)
+		}
+		b.values[i] = randT[V](r, opts.ValueLen)
+	}
+	if opts.AllowNaN {
+		injectNaN(b.keys)
+	}
+	b.unique = b.keys
+	if opts.DupRatio > 0 {
+		injectDups(b.keys, seed, opts.DupRatio)
+		b.unique = uniqueKeys(b.keys)
+	}
+	b.misses = newMisses(b.keys, seed, opts.KeyLen)
+	if opts.Distribution == "zipf" && size > 0 {
+		b.lookups = zipfLookups(len(b.keys), seed, opts.ZipfS)
 	}
 
 	return b
 }
 
+// nanEvery is the stride between the NaN keys injected with Options.AllowNaN.
+const nanEvery = 1000
+
+// injectNaN replaces every nanEvery-th key with NaN if K is a float type.
+func injectNaN[K any](keys []K) {
+	var nan K
+	switch any(nan).(type) {
+	case float64:
+		nan = any(math.NaN()).(K)
+	case float32:
+		nan = any(float32(math.NaN())).(K)
+	default:
+		return
+	}
+	for i := 0; i < len(keys); i += nanEvery {
+		keys[i] = nan
+	}
+}
+
+// injectDups replaces each key but the first with a copy of a random earlier
+// key with probability ratio.
+func injectDups[K any](keys []K, seed uint64, ratio float64) {
+	r := rand.New(seed, 5)
+	for i := 1; i < len(keys); i++ {
+		if r.Float64() < ratio {
+			keys[i] = keys[r.Intn(i)]
+		}
+	}
+}
+
+// uniqueKeys returns the distinct keys, in the order of their first
+// occurrence. Like the maps, it keeps every NaN key.
+func uniqueKeys[K comparable](keys []K) []K {
+	seen := make(map[K]struct{}, len(keys))
+	unique := make([]K, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
+
+// zipfLookups returns n indexes in the range [0, n) following a Zipf
+// distribution with exponent s.
+func zipfLookups(n int, seed uint64, s float64) []int {
+	z := rand.NewZipf(rand.New(seed, 2), s, 1, uint64(n-1))
+	lookups := make([]int, n)
+	for i := range lookups {
+		lookups[i] = int(z.Uint64())
+	}
+	return lookups
+}
+
+// newMisses generates up to len(keys) keys that are not present in keys. It
+// uses a separate PRNG stream and drops the keys that happen to collide with
+// the dataset. Key types with a small domain, such as struct{}, may yield
+// fewer keys or none at all.
+func newMisses[K comparable](keys []K, seed uint64, keyLen int) []K {
+	present := make(map[K]struct{}, len(keys))
+	for _, key := range keys {
+		present[key] = struct{}{}
+	}
+	r := rand.New(seed, 1)
+	misses := make([]K, 0, len(keys))
+	for attempts := 0; len(misses) < len(keys) && attempts < 2*len(keys); attempts++ {
+		key := randT[K](r, keyLen)
+		if _, ok := present[key]; !ok {
+			misses = append(misses, key)
+		}
+	}
+	return misses
+}
+
+// benchmarkInsert fills a new map on every iteration. With Options.Reuse it
+// fills the same map instead, clearing it with the timer stopped, so that the
+// cost of allocating the map is left out.
 func (bench *Bench[K, V]) benchmarkInsert(b *testing.B) {
+	if bench.opts.Reuse {
+		m := bench.m()
+		// b.Loop doesn't cope with a stopped timer, hence the b.N loop.
+		for range b.N {
+			b.StopTimer()
+			m.Clear()
+			b.StartTimer()
+			for i, key := range bench.keys {
+				m.Set(key, bench.values[i])
+			}
+		}
+		return
+	}
 	for i := 0; b.Loop(); i++ {
 		m := bench.m()
 		for i, key := range bench.keys {
@@ -75,17 +412,363 @@ func (bench *Bench[K, V]) benchmarkInsert(b *testing.B) {
 	}
 }
 
-func (bench *Bench[K, V]) benchmarkLookup(b *testing.B) {
+// benchmarkClear measures the cost of resetting a populated map with Clear
+// and filling it again, to compare with filling a new map in Insert. Run
+// prints the difference when both phases run.
+func (bench *Bench[K, V]) benchmarkClear(b *testing.B) {
+	m := bench.fill()
+	b.ResetTimer()
+	for b.Loop() {
+		m.Clear()
+		for i, key := range bench.keys {
+			m.Set(key, bench.values[i])
+		}
+	}
+}
+
+// printStats prints the probe length and size statistics of a filled map,
+// for the maps that report them, and its probe length histogram with
+// Options.Histogram.
+func (bench *Bench[K, V]) printStats() {
+	filled := bench.fill()
+	if p, ok := filled.(ProbeStater); ok {
+		avg, max := p.ProbeStats()
+		fmt.Printf("Probe length: avg = %.3f, max = %d\n", avg, max)
+	}
+	if sz, ok := filled.(Sized); ok && sz.Cap() > 0 {
+		// Cap is where the map grows, so this is the fill level relative
+		// to the growth threshold of the implementation, not to its slots.
+		fmt.Printf("Size: len = %d, cap = %d, load factor = %.3f\n",
+			sz.Len(), sz.Cap(), float64(sz.Len())/float64(sz.Cap()))
+	}
+	if p, ok := filled.(ProbeHistogrammer); ok && bench.opts.Histogram {
+		fmt.Println("Probe length histogram:")
+		for d, n := range p.ProbeHistogram() {
+			fmt.Printf("  %3d: %d\n", d, n)
+		}
+	}
+}
+
+func (bench *Bench[K, V]) fill() Map[K, V] {
 	m := bench.m()
 	for i, key := range bench.keys {
 		m.Set(key, bench.values[i])
 	}
+	return m
+}
+
+func (bench *Bench[K, V]) benchmarkLookup(b *testing.B) {
+	m := bench.fill()
 	b.ResetTimer()
+	if bench.lookups != nil {
+		for i := 0; b.Loop(); i++ {
+			_, _ = m.Get(bench.keys[bench.lookups[i%len(bench.lookups)]])
+		}
+		return
+	}
 	for i := 0; b.Loop(); i++ {
 		_, _ = m.Get(bench.keys[i%len(bench.keys)])
 	}
 }
 
+// benchmarkLookupRatio looks up a mix of present and missing keys, where a key
+// is present with probability HitRatio. Present keys follow the lookup
+// distribution. The query sequence is generated from the dataset seed before
+// the timer starts.
+func (bench *Bench[K, V]) benchmarkLookupRatio(b *testing.B) {
+	m := bench.fill()
+	r := rand.New(bench.seed, 3)
+	queries := make([]K, len(bench.keys))
+	for i := range queries {
+		switch {
+		case len(bench.misses) > 0 && r.Float64() >= bench.opts.HitRatio:
+			queries[i] = bench.misses[r.Intn(len(bench.misses))]
+		case bench.lookups != nil:
+			queries[i] = bench.keys[bench.lookups[i]]
+		default:
+			queries[i] = bench.keys[r.Intn(len(bench.keys))]
+		}
+	}
+	b.ResetTimer()
+	for i := 0; b.Loop(); i++ {
+		_, _ = m.Get(queries[i%len(queries)])
+	}
+}
+
+func (bench *Bench[K, V]) benchmarkLookupMiss(b *testing.B) {
+	m := bench.fill()
+	b.ResetTimer()
+	for i := 0; b.Loop(); i++ {
+		_, _ = m.Get(bench.misses[i%len(bench.misses)])
+	}
+}
+
+// benchmarkChurn deletes one key and inserts another one per iteration, so the
+// number of live entries stays at the number of unique keys. The i-th unique
+// key is swapped with the i-th missing key, and back on the next pass over
+// the dataset.
+func (bench *Bench[K, V]) benchmarkChurn(b *testing.B) {
+	m := bench.fill()
+	n := min(len(bench.misses), len(bench.unique))
+	b.ResetTimer()
+	for i := 0; b.Loop(); i++ {
+		j := i % n
+		old, fresh := bench.unique[j], bench.misses[j]
+		if (i/n)%2 == 1 {
+			old, fresh = fresh, old
+		}
+		m.Delete(old)
+		m.Set(fresh, bench.values[j])
+	}
+}
+
+// benchmarkSteadyStateChurn is like benchmarkChurn, but deletes a random live
+// key per iteration. Deleted keys are put back into the pool of spare keys,
+// which starts as the missing keys, so the map size stays constant. It
+// returns the number of rehashes of the last run for maps that count them,
+// or -1 otherwise.
+func (bench *Bench[K, V]) benchmarkSteadyStateChurn() (testing.BenchmarkResult, int) {
+	rehashes := -1
+	t := testing.Benchmark(func(b *testing.B) {
+		m := bench.fill()
+		live := slices.Clone(bench.unique)
+		spare := slices.Clone(bench.misses)
+		r := rand.New(bench.seed, 4)
+		var before int
+		rh, counted := m.(Rehasher)
+		if counted {
+			before = rh.Rehashes()
+		}
+		b.ResetTimer()
+		for i := 0; b.Loop(); i++ {
+			j, k := r.Intn(len(live)), i%len(spare)
+			m.Delete(live[j])
+			m.Set(spare[k], bench.values[j])
+			live[j], spare[k] = spare[k], live[j]
+		}
+		if counted {
+			rehashes = rh.Rehashes() - before
+		}
+	})
+	return t, rehashes
+}
+
+// churnPasses bounds the churn of benchmarkLookupAfterChurn, in passes over
+// the unique keys, for maps that count their resizes.
+const churnPasses = 4
+
+// benchmarkLookupAfterChurn measures Get on a map degraded by churn, to
+// compare with the freshly built map of Lookup. A populated map goes through
+// the steady-state churn of benchmarkSteadyStateChurn for one pass over the
+// unique keys. Maps that count their resizes are churned for up to
+// churnPasses passes instead, stopping right before their first rehash,
+// which would clean them up. The live keys are then looked up in turn. It
+// returns the churned map and the number of churn operations done.
+func (bench *Bench[K, V]) benchmarkLookupAfterChurn() (testing.BenchmarkResult, Map[K, V], int) {
+	limit := len(bench.unique)
+	m := bench.fill()
+	if _, ok := m.(Rehasher); ok {
+		limit *= churnPasses
+	}
+	live, ops, rehashed := bench.churn(m, limit)
+	if rehashed {
+		// The churn is deterministic, so replaying it on a new map stops
+		// right before the rehash.
+		m = bench.fill()
+		live, ops, _ = bench.churn(m, ops-1)
+	}
+	t := testing.Benchmark(func(b *testing.B) {
+		for i := 0; b.Loop(); i++ {
+			_, _ = m.Get(live[i%len(live)])
+		}
+	})
+	return t, m, ops
+}
+
+// churn deletes a random live key and inserts a spare one up to ops times, as
+// benchmarkSteadyStateChurn does, stopping after the first operation that
+// rehashes maps that count their resizes. It returns the live keys, the
+// number of operations done and whether the map was rehashed.
+func (bench *Bench[K, V]) churn(m Map[K, V], ops int) ([]K, int, bool) {
+	live := slices.Clone(bench.unique)
+	spare := slices.Clone(bench.misses)
+	r := rand.New(bench.seed, 6)
+	rh, counted := m.(Rehasher)
+	var before int
+	if counted {
+		before = rh.Rehashes()
+	}
+	for i := range ops {
+		j, k := r.Intn(len(live)), i%len(spare)
+		m.Delete(live[j])
+		m.Set(spare[k], bench.values[j])
+		live[j], spare[k] = spare[k], live[j]
+		if counted && rh.Rehashes() != before {
+			return live, i + 1, true
+		}
+	}
+	return live, ops, false
+}
+
+func (bench *Bench[K, V]) benchmarkContains(b *testing.B) {
+	m := bench.fill()
+	b.ResetTimer()
+	for i := 0; b.Loop(); i++ {
+		_ = m.Contains(bench.keys[i%len(bench.keys)])
+	}
+}
+
+// benchmarkIterate ranges over all the entries of a populated map. The
+// entries are counted and checked against the number of unique keys, so the
+// scan can't be optimized away. The cost per entry is reported as an extra
+// metric.
+func (bench *Bench[K, V]) benchmarkIterate(b *testing.B) {
+	m := bench.fill()
+	b.ResetTimer()
+	for b.Loop() {
+		n := 0
+		m.Iterate(func(K, V) bool {
+			n++
+			return true
+		})
+		if n != len(bench.unique) {
+			b.Fatalf("iterated over %d entries, want %d", n, len(bench.unique))
+		}
+	}
+	if len(bench.unique) > 0 {
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(bench.unique)), "ns/entry")
+	}
+}
+
+// benchmarkParallelLookup issues Get calls from multiple goroutines against a
+// single shared map. The map is populated before the timer starts and is only
+// read afterwards, since none of the swiss maps, nor std, are safe for
+// concurrent writes. Comparing with -map-type=syncmap shows how they fare
+// against sync.Map for read-only concurrent access.
+func (bench *Bench[K, V]) benchmarkParallelLookup(b *testing.B) {
+	m := bench.fill()
+	var worker atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Start every goroutine at a different key.
+		i := int(worker.Add(1)) * len(bench.keys) / runtime.GOMAXPROCS(0)
+		for ; pb.Next(); i++ {
+			_, _ = m.Get(bench.keys[i%len(bench.keys)])
+		}
+	})
+}
+
+// benchmarkGrowth inserts the dataset one key at a time into a new map and
+// reports the total time along with the number of resizes. Maps that don't
+// count resizes themselves are polled for capacity changes after every
+// insertion, which slightly inflates their time.
+func (bench *Bench[K, V]) benchmarkGrowth() {
+	m := bench.m()
+	r, counted := m.(Rehasher)
+	c, capped := m.(Sized)
+	resizes, last := 0, 0
+	if capped {
+		last = c.Cap()
+	}
+	start := time.Now()
+	for i, key := range bench.keys {
+		m.Set(key, bench.values[i])
+		if !counted && capped {
+			if cp := c.Cap(); cp != last {
+				resizes, last = resizes+1, cp
+			}
+		}
+	}
+	elapsed := time.Since(start)
+
+	switch {
+	case counted:
+		fmt.Printf("Growth: %v, resizes = %d\n", elapsed, r.Rehashes())
+	case capped:
+		fmt.Printf("Growth: %v, resizes = %d (observed from capacity)\n", elapsed, resizes)
+	default:
+		fmt.Printf("Growth: %v, resizes = n/a\n", elapsed)
+	}
+}
+
+// benchmarkGrowthCurve inserts the dataset one key at a time into a new map,
+// like benchmarkGrowth, and prints the cumulative time at every entry count
+// in Options.GrowthMilestones along with the time per insertion since the
+// previous milestone, where the cost of resizes shows up as spikes.
+func (bench *Bench[K, V]) benchmarkGrowthCurve() {
+	milestones := bench.opts.GrowthMilestones
+	elapsed := make([]time.Duration, 0, len(milestones))
+	rehashes := make([]int, 0, len(milestones))
+	m := bench.m()
+	r, counted := m.(Rehasher)
+	start := time.Now()
+	for i, key := range bench.keys {
+		m.Set(key, bench.values[i])
+		if len(elapsed) < len(milestones) && i+1 == milestones[len(elapsed)] {
+			elapsed = append(elapsed, time.Since(start))
+			if counted {
+				rehashes = append(rehashes, r.Rehashes())
+			}
+		}
+	}
+
+	fmt.Println("Growth curve:")
+	fmt.Printf("  %12s %14s %12s", "entries", "total ns", "ns/insert")
+	if counted {
+		fmt.Printf(" %9s", "rehashes")
+	}
+	fmt.Println()
+	prevN, prevT := 0, time.Duration(0)
+	for i, t := range elapsed {
+		n := milestones[i]
+		fmt.Printf("  %12d %14d %12.1f", n, t.Nanoseconds(), float64((t-prevT).Nanoseconds())/float64(n-prevN))
+		if counted {
+			fmt.Printf(" %9d", rehashes[i])
+		}
+		fmt.Println()
+		prevN, prevT = n, t
+	}
+}
+
+const latencySamples = 1_000_000
+
+// benchmarkLookupLatency times latencySamples individual Get calls outside of
+// testing.Benchmark and prints the latency percentiles. The looked up keys
+// are drawn from the dataset by a PRNG seeded with the dataset seed, so the
+// sequence is the same from run to run.
+func (bench *Bench[K, V]) benchmarkLookupLatency() {
+	m := bench.fill()
+	r := rand.New(bench.seed)
+	samples := make([]time.Duration, latencySamples)
+	for i := range samples {
+		key := bench.keys[r.Intn(len(bench.keys))]
+		start := time.Now()
+		_, _ = m.Get(key)
+		samples[i] = time.Since(start)
+	}
+	slices.Sort(samples)
+	percentile := func(p float64) time.Duration {
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	fmt.Printf("Lookup latency: p50 = %v, p90 = %v, p99 = %v, p99.9 = %v, max = %v\n",
+		percentile(0.5), percentile(0.9), percentile(0.99), percentile(0.999), samples[len(samples)-1])
+}
+
+// measureMapMemory reports the heap retained by a fully populated map. The
+// dataset is alive both before and after the map is built, so it doesn't
+// contribute to the reported delta.
+func (bench *Bench[K, V]) measureMapMemory() {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	m := bench.fill()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(m)
+	retained := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	fmt.Printf("Map Memory: %v KB for %d entries\n", retained/1024, m.Len())
+}
+
 func measureMemoryUsage() {
 	runtime.GC()
 	var m runtime.MemStats
@@ -93,12 +776,168 @@ func measureMemoryUsage() {
 	fmt.Printf("Memory Usage: Alloc = %v KB, Sys = %v KB, NumGC = %v\n", m.Alloc/1024, m.Sys/1024, m.NumGC)
 }
 
-func (bench *Bench[K, V]) Run() {
-	t := testing.Benchmark(bench.benchmarkInsert)
-	fmt.Printf("Insert: %v\n", t)
+// Phases lists the names of the benchmark phases whose results Run returns,
+// in the order they run.
+var Phases = []string{
+	"Insert", "Clear", "Lookup", "LookupMiss", "LookupRatio", "Contains",
+	"Iterate", "Churn", "SteadyStateChurn", "LookupAfterChurn", "ParallelLookup",
+}
+
+// Reports lists the names of the untimed phases of Run, which only print
+// what they measure: the probe and size statistics of a filled map, the
+// insertion of the dataset one key at a time, and the memory usage. They can
+// be selected along with the timed phases.
+var Reports = []string{"Stats", "Growth", "Memory"}
+
+// Result is the outcome of a single benchmark phase. For repeated phases it
+// holds the median run.
+type Result struct {
+	Phase string
+	testing.BenchmarkResult
+	Spread Spread
+}
+
+func (r Result) String() string {
+	return r.BenchmarkResult.String() + r.Spread.String()
+}
+
+// enabled reports whether the phase, timed or not, was selected by
+// Options.Phases.
+func (bench *Bench[K, V]) enabled(phase string) bool {
+	return bench.opts.Phases == nil || bench.opts.Phases[phase]
+}
+
+// Run runs the benchmark phases enabled by the options, prints their results
+// and returns the results of the timed phases.
+func (bench *Bench[K, V]) Run() []Result {
+	var (
+		results []Result
+		t       Result
+	)
+
+	if bench.opts.DupRatio > 0 {
+		fmt.Printf("Dataset: %d keys, %d unique\n", len(bench.keys), len(bench.unique))
+	}
+
+	var insert Result
+	if bench.enabled("Insert") {
+		t = bench.measure("Insert", bench.benchmarkInsert)
+		fmt.Printf("Insert: %v\n", t)
+		results = append(results, t)
+		insert = t
+	}
+
+	if bench.enabled("Clear") {
+		t = bench.measure("Clear", bench.benchmarkClear)
+		fmt.Printf("Clear (reset + refill): %v", t)
+		// With Reuse, Insert clears and refills too, so there is nothing
+		// to compare with.
+		if base := nsPerOp(insert.BenchmarkResult); base > 0 && !bench.opts.Reuse {
+			fmt.Printf(", %+.1f%% vs Insert into a new map", (nsPerOp(t.BenchmarkResult)/base-1)*100)
+		}
+		fmt.Println()
+		results = append(results, t)
+	}
+
+	if bench.enabled("Stats") {
+		bench.printStats()
+	}
 
-	t = testing.Benchmark(bench.benchmarkLookup)
-	fmt.Printf("Lookup: %v\n", t)
+	if bench.enabled("Growth") {
+		bench.benchmarkGrowth()
+	}
+	if len(bench.opts.GrowthMilestones) > 0 {
+		bench.benchmarkGrowthCurve()
+	}
+
+	var lookup Result
+	if bench.enabled("Lookup") {
+		t = bench.measure("Lookup", bench.benchmarkLookup)
+		fmt.Printf("Lookup: %v\n", t)
+		results = append(results, t)
+		lookup = t
+	}
+
+	if len(bench.misses) > 0 && bench.enabled("LookupMiss") {
+		t = bench.measure("LookupMiss", bench.benchmarkLookupMiss)
+		fmt.Printf("LookupMiss: %v\n", t)
+		results = append(results, t)
+	}
+
+	if bench.opts.HitRatio >= 0 && bench.enabled("LookupRatio") {
+		t = bench.measure("LookupRatio", bench.benchmarkLookupRatio)
+		fmt.Printf("LookupRatio (hit ratio %.2f): %v\n", bench.opts.HitRatio, t)
+		results = append(results, t)
+	}
+
+	if bench.enabled("Contains") {
+		t = bench.measure("Contains", bench.benchmarkContains)
+		fmt.Printf("Contains: %v\n", t)
+		results = append(results, t)
+	}
+
+	if bench.enabled("Iterate") {
+		t = bench.measure("Iterate", bench.benchmarkIterate)
+		fmt.Printf("Iterate: %v\n", t)
+		results = append(results, t)
+	}
+
+	if len(bench.misses) > 0 && bench.enabled("Churn") {
+		t = bench.measure("Churn", bench.benchmarkChurn)
+		fmt.Printf("Churn: %v\n", t)
+		results = append(results, t)
+	}
+
+	if len(bench.misses) > 0 && bench.enabled("SteadyStateChurn") {
+		var rehashes int
+		t = bench.repeat("SteadyStateChurn", func() testing.BenchmarkResult {
+			var r testing.BenchmarkResult
+			r, rehashes = bench.benchmarkSteadyStateChurn()
+			return r
+		})
+		if rehashes >= 0 {
+			fmt.Printf("SteadyStateChurn: %v, rehashes = %d\n", t, rehashes)
+		} else {
+			fmt.Printf("SteadyStateChurn: %v\n", t)
+		}
+		results = append(results, t)
+	}
+
+	if len(bench.misses) > 0 && bench.enabled("LookupAfterChurn") {
+		var (
+			churned Map[K, V]
+			ops     int
+		)
+		t = bench.repeat("LookupAfterChurn", func() testing.BenchmarkResult {
+			var r testing.BenchmarkResult
+			r, churned, ops = bench.benchmarkLookupAfterChurn()
+			return r
+		})
+		fmt.Printf("LookupAfterChurn: %v, churn ops = %d", t, ops)
+		if ts, ok := churned.(Tombstoner); ok {
+			fmt.Printf(", tombstones = %d", ts.Tombstones())
+		}
+		if base := nsPerOp(lookup.BenchmarkResult); base > 0 {
+			fmt.Printf(", %+.1f%% vs Lookup", (nsPerOp(t.BenchmarkResult)/base-1)*100)
+		}
+		fmt.Println()
+		results = append(results, t)
+	}
+
+	if bench.opts.Parallel && bench.enabled("ParallelLookup") {
+		t = bench.measure("ParallelLookup", bench.benchmarkParallelLookup)
+		fmt.Printf("ParallelLookup (GOMAXPROCS=%d): %v\n", runtime.GOMAXPROCS(0), t)
+		results = append(results, t)
+	}
+
+	if bench.opts.Latency {
+		bench.benchmarkLookupLatency()
+	}
+
+	if bench.enabled("Memory") {
+		bench.measureMapMemory()
+		measureMemoryUsage()
+	}
 
-	measureMemoryUsage()
+	return results
 }
@@ -3,47 +3,442 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unsafe"
 
 	cocroach "github.com/cockroachdb/swiss"
 	crn4 "github.com/crn4/swiss"
+	crn4hash "github.com/crn4/swiss/hash"
 	dolthub "github.com/dolthub/swiss"
 )
 
 func main() {
 	var (
-		seed, size         uint64
-		mapType            string
-		keyType, valueType string
+		cfg       config
+		cpu       int
+		phases    string
+		benchtime string
+		growth    string
+		sweep     string
+		sizes     string
+		cpuprof   string
+		memprof   string
 	)
-	flag.Uint64Var(&seed, "seed", 1234, "Seed value for random generator")
-	flag.Uint64Var(&size, "dataset-size", 1_000_000, "Number of elements in the dataset")
-	flag.StringVar(&mapType, "map-type", "std", "std/cocroach/crn4/dolthub")
-	flag.StringVar(&keyType, "key-type", "int", "int/string/struct{}")
-	flag.StringVar(&valueType, "value-type", "int", "int/string/struct{}")
+	flag.Uint64Var(&cfg.seed, "seed", 1234, "Seed of the dataset generator and of the cocroach and crn4 hashes; their layout is reproduced across runs only with -hash=xxhash")
+	flag.StringVar(&cfg.opts.Hash, "hash", "runtime", "Hash function of the cocroach and crn4 maps: runtime/xxhash; xxhash reproduces their layout across runs")
+	flag.BoolVar(&cfg.opts.Pow2Groups, "pow2-groups", false, "Round the number of crn4 groups up to a power of two, masking h1 instead of dividing it")
+	flag.Uint64Var(&cfg.size, "dataset-size", 1_000_000, "Number of elements in the dataset")
+	flag.StringVar(&cfg.mapType, "map-type", "std", "std/syncmap/cocroach/crn4/crn4-concurrent/dolthub")
+	flag.StringVar(&cfg.keyType, "key-type", "int", "int/uint64/uint32/float32/float64/string/struct{}/struct2/*int")
+	flag.StringVar(&cfg.valueType, "value-type", "int", "int/string/struct{}/struct8/struct64/struct256")
+	flag.StringVar(&sizes, "value-size", "", "Comma separated struct value sizes in bytes, 8/64/256, to run the suite with, one after the other; overrides -value-type")
+	flag.BoolVar(&cfg.opts.AllowNaN, "allow-nan", false, "Make every 1000th float key NaN, which no map can find again")
+	flag.IntVar(&cfg.opts.KeyLen, "key-len", 7, "Length of random string keys, including the string field of struct2")
+	flag.IntVar(&cfg.opts.ValueLen, "value-len", 7, "Length of random string values")
+	flag.BoolVar(&cfg.presize, "presize", false, "Create the maps with the dataset size as a size hint")
+	flag.BoolVar(&cfg.presize, "prealloc", false, "Alias for -presize")
+	flag.BoolVar(&cfg.verify, "verify", false, "Check every map type against std instead of benchmarking")
+	flag.BoolVar(&cfg.opts.Parallel, "parallel", false, "Run the concurrent read-only lookup benchmark")
+	flag.BoolVar(&cfg.opts.Parallel, "concurrent", false, "Alias for -parallel")
+	flag.BoolVar(&cfg.opts.Histogram, "histogram", false, "Print the probe length histogram of maps that support it")
+	flag.BoolVar(&cfg.opts.Latency, "latency", false, "Report lookup latency percentiles (slower, allocates the samples)")
+	flag.StringVar(&cfg.opts.Distribution, "distribution", "uniform", "Lookup key distribution: uniform/zipf")
+	flag.Float64Var(&cfg.opts.ZipfS, "zipf-s", 1.1, "Exponent of the zipf lookup distribution, must be > 1")
+	flag.StringVar(&cfg.opts.KeyDist, "key-dist", "random", "Dataset key distribution: random/sequential/clustered/colliding; sequential and clustered need integer or string keys")
+	flag.IntVar(&cfg.opts.Runs, "runs", 1, "Number of times to repeat every timed phase, reporting min/median/max and the coefficient of variation")
+	flag.IntVar(&cfg.opts.Runs, "repeat", 1, "Alias for -runs")
+	flag.BoolVar(&cfg.opts.Reuse, "reuse", false, "Clear and refill a single map in the Insert benchmark instead of allocating a new one")
+	flag.Float64Var(&cfg.opts.DupRatio, "dup-ratio", 0, "Fraction of dataset keys that repeat an earlier key, 0.0-1.0 exclusive, so that Insert also updates entries")
+	flag.Float64Var(&cfg.opts.HitRatio, "hit-ratio", -1, "Fraction of present keys in the mixed lookup benchmark, 0.0-1.0; negative disables it")
+	flag.StringVar(&cfg.output, "output", "text", "Result format: text/csv; csv also prints the text results")
+	flag.StringVar(&cfg.csvFile, "csv-file", "", "File to append the csv row to; stdout if empty")
+	flag.StringVar(&cfg.resultsFile, "results-file", "", "File to append the results of the run to as a JSON line")
+	flag.StringVar(&cfg.baseline, "baseline", "", "Results file to compare the run with; exits with status 1 on a regression")
+	flag.Float64Var(&cfg.threshold, "threshold", 5, "Slowdown in percent of any phase over the baseline that counts as a regression")
+	flag.StringVar(&benchtime, "benchtime", "", "Budget of every timed phase, as go test -benchtime: a duration like 2s or an iteration count like 100x; 1s if empty")
+	flag.StringVar(&growth, "growth-curve", "", "Comma separated entry counts to sample the growth curve at, or pow2 for the powers of two up to the dataset size; disabled if empty")
+	flag.StringVar(&phases, "phases", "", "Comma separated phases to run, e.g. insert,lookup,lookupratio; the untimed stats, growth and memory reports are phases too; all if empty")
+	flag.StringVar(&sweep, "gomaxprocs", "", "Comma separated GOMAXPROCS values to run the whole suite with, one after the other")
+	flag.IntVar(&cpu, "cpu", 0, "GOMAXPROCS value to run with; 0 keeps the default")
+	flag.StringVar(&cpuprof, "cpuprofile", "", "File to write a CPU profile of the whole run to, for go tool pprof")
+	flag.StringVar(&memprof, "memprofile", "", "File to write a heap profile to once the run is over, for go tool pprof")
 	flag.Parse()
 
-	build := func() Map[int, int] { return NewSimpleMap[int, int]() }
-	switch mapType {
-	case "cocroach":
-		build = func() Map[int, int] { return NewCocroachMap[int, int]() }
-	case "crn4":
-		build = func() Map[int, int] { return NewCRN4Map[int, int]() }
-	case "dolthub":
-		build = func() Map[int, int] { return NewDolthubMap[int, int]() }
+	if d := cfg.opts.Distribution; d != "uniform" && d != "zipf" {
+		fmt.Fprintf(os.Stderr, "unsupported distribution: %s\n", d)
+		os.Exit(2)
 	}
-	b := New[int, int](size, seed, build)
+	if cfg.opts.ZipfS <= 1 {
+		fmt.Fprintf(os.Stderr, "zipf exponent must be greater than 1: %v\n", cfg.opts.ZipfS)
+		os.Exit(2)
+	}
+	switch cfg.opts.KeyDist {
+	case "random", "sequential", "clustered", "colliding":
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported key distribution: %s\n", cfg.opts.KeyDist)
+		os.Exit(2)
+	}
+	if cfg.opts.HitRatio > 1 {
+		fmt.Fprintf(os.Stderr, "hit ratio must be in the range [0, 1]: %v\n", cfg.opts.HitRatio)
+		os.Exit(2)
+	}
+	if cfg.opts.DupRatio < 0 || cfg.opts.DupRatio >= 1 {
+		fmt.Fprintf(os.Stderr, "dup ratio must be in the range [0, 1): %v\n", cfg.opts.DupRatio)
+		os.Exit(2)
+	}
+	if cfg.output != "text" && cfg.output != "csv" {
+		fmt.Fprintf(os.Stderr, "unsupported output format: %s\n", cfg.output)
+		os.Exit(2)
+	}
+	switch {
+	case cfg.opts.Hash != "runtime" && cfg.opts.Hash != "xxhash":
+		fmt.Fprintf(os.Stderr, "unsupported hash: %s\n", cfg.opts.Hash)
+		os.Exit(2)
+	case cfg.opts.Hash == "xxhash" && cfg.keyType == "struct2":
+		// xxhash hashes the memory of struct keys, which holds the string
+		// header, so GetHashFuncXX would panic.
+		fmt.Fprintln(os.Stderr, "xxhash doesn't support struct2 keys, which contain a string")
+		os.Exit(2)
+	}
+	if cfg.opts.KeyLen < 1 || cfg.opts.ValueLen < 1 {
+		fmt.Fprintf(os.Stderr, "string lengths must be at least 1: key %d, value %d\n", cfg.opts.KeyLen, cfg.opts.ValueLen)
+		os.Exit(2)
+	}
+	if cfg.opts.Runs < 1 {
+		fmt.Fprintf(os.Stderr, "runs must be at least 1: %d\n", cfg.opts.Runs)
+		os.Exit(2)
+	}
+	if phases != "" {
+		selected, err := parsePhases(phases)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		cfg.opts.Phases = selected
+	}
+	if growth != "" {
+		milestones, err := parseMilestones(growth, cfg.size)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		cfg.opts.GrowthMilestones = milestones
+	}
+	if benchtime != "" {
+		// testing.Benchmark reads its budget from the -test.benchtime flag,
+		// which only exists once testing.Init has registered it.
+		testing.Init()
+		if err := flag.Set("test.benchtime", benchtime); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid benchtime %q: %v\n", benchtime, err)
+			os.Exit(2)
+		}
+	}
+	if sizes != "" {
+		for _, field := range strings.Split(sizes, ",") {
+			switch size, _ := strconv.Atoi(strings.TrimSpace(field)); size {
+			case 8, 64, 256:
+				cfg.valueSizes = append(cfg.valueSizes, size)
+			default:
+				fmt.Fprintf(os.Stderr, "unsupported value size: %q, want 8, 64 or 256\n", field)
+				os.Exit(2)
+			}
+		}
+	}
+	if sweep != "" {
+		if cpu > 0 {
+			fmt.Fprintln(os.Stderr, "-cpu and -gomaxprocs are mutually exclusive")
+			os.Exit(2)
+		}
+		for _, field := range strings.Split(sweep, ",") {
+			p, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || p < 1 {
+				fmt.Fprintf(os.Stderr, "invalid GOMAXPROCS value: %q\n", field)
+				os.Exit(2)
+			}
+			cfg.gomaxprocs = append(cfg.gomaxprocs, p)
+		}
+	}
+	// Checked before the profiles start, so that a typo doesn't leave an
+	// empty profile behind.
+	if !slices.Contains(keyTypes, cfg.keyType) {
+		fmt.Fprintf(os.Stderr, "unsupported key type: %s\n", cfg.keyType)
+		os.Exit(2)
+	}
+	if d := cfg.opts.KeyDist; (d == "sequential" || d == "clustered") && !slices.Contains(seqKeyTypes, cfg.keyType) {
+		fmt.Fprintf(os.Stderr, "-key-dist=%s supports only %s keys, not %s\n", d, strings.Join(seqKeyTypes, "/"), cfg.keyType)
+		os.Exit(2)
+	}
+	if len(cfg.valueSizes) == 0 && !slices.Contains(valueTypes, cfg.valueType) {
+		fmt.Fprintf(os.Stderr, "unsupported value type: %s\n", cfg.valueType)
+		os.Exit(2)
+	}
+	if cpu > 0 {
+		runtime.GOMAXPROCS(cpu)
+	}
+
+	if err := startProfiles(cpuprof, memprof); err != nil {
+		fmt.Fprintf(os.Stderr, "starting profiles: %v\n", err)
+		os.Exit(2)
+	}
+	defer stopProfiles()
+	if len(cfg.valueSizes) == 0 {
+		runConfig(cfg)
+		return
+	}
+	for _, size := range cfg.valueSizes {
+		fmt.Printf("Value size: %d bytes\n", size)
+		cfg.valueType = fmt.Sprintf("struct%d", size)
+		runConfig(cfg)
+	}
+}
+
+// exit stops the profiles, which os.Exit would leave unwritten, and exits
+// with the given status.
+func exit(code int) {
+	stopProfiles()
+	os.Exit(code)
+}
+
+// keyTypes and valueTypes are the -key-type and -value-type values runConfig
+// and runWithKey handle. seqKeyTypes are the key types seqT can generate for
+// the sequential and clustered key distributions.
+var (
+	keyTypes    = []string{"int", "uint64", "uint32", "float32", "float64", "string", "struct{}", "struct2", "*int"}
+	valueTypes  = []string{"int", "string", "struct{}", "struct8", "struct64", "struct256"}
+	seqKeyTypes = []string{"int", "uint64", "uint32", "string"}
+)
+
+// runConfig runs the benchmarks, or the verification, for the key and value
+// types selected by cfg.
+func runConfig(cfg config) {
+	switch cfg.keyType {
+	case "int":
+		runWithKey[int](cfg)
+	case "uint64":
+		runWithKey[uint64](cfg)
+	case "uint32":
+		runWithKey[uint32](cfg)
+	case "float32":
+		runWithKey[float32](cfg)
+	case "float64":
+		runWithKey[float64](cfg)
+	case "string":
+		runWithKey[string](cfg)
+	case "struct{}":
+		runWithKey[struct{}](cfg)
+	case "struct2":
+		runWithKey[Struct2](cfg)
+	case "*int":
+		runWithKey[*int](cfg)
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported key type: %s\n", cfg.keyType)
+		exit(2)
+	}
+}
+
+// parsePhases parses the comma separated list of the -phases flag. The names
+// are matched against Phases and Reports case-insensitively.
+func parsePhases(list string) (map[string]bool, error) {
+	valid := slices.Concat(Phases, Reports)
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		i := slices.IndexFunc(valid, func(p string) bool { return strings.EqualFold(p, name) })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown phase %q, valid phases are: %s", name, strings.Join(valid, ", "))
+		}
+		selected[valid[i]] = true
+	}
+	return selected, nil
+}
+
+// parseMilestones parses the -growth-curve flag into sorted, distinct entry
+// counts in the range [1, size]. "pow2" stands for the powers of two up to
+// size, followed by size itself.
+func parseMilestones(list string, size uint64) ([]int, error) {
+	var milestones []int
+	if list == "pow2" {
+		for n := uint64(1); n <= size; n *= 2 {
+			milestones = append(milestones, int(n))
+		}
+		if size > 0 && size&(size-1) != 0 {
+			milestones = append(milestones, int(size))
+		}
+		return milestones, nil
+	}
+	for _, field := range strings.Split(list, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(field), 10, 64)
+		if err != nil || n == 0 || n > size {
+			return nil, fmt.Errorf("invalid growth milestone %q, want an entry count in the range [1, %d]", field, size)
+		}
+		milestones = append(milestones, int(n))
+	}
+	slices.Sort(milestones)
+	return slices.Compact(milestones), nil
+}
+
+// Struct2 is a composite key used by -key-type=struct2.
+type Struct2 struct {
+	A int
+	B string
+}
 
-	fmt.Println("Running Map Benchmarks")
+// Struct8, Struct64 and Struct256 are values of 8, 64 and 256 bytes used by
+// -value-type=struct8, struct64 and struct256, or -value-size, to measure the
+// cost of copying values.
+type (
+	Struct8   struct{ B [8]byte }
+	Struct64  struct{ B [64]byte }
+	Struct256 struct{ B [256]byte }
+)
+
+type config struct {
+	mapType            string
+	keyType, valueType string
+	size, seed         uint64
+	verify             bool
+	presize            bool
+	output, csvFile    string
+	resultsFile        string
+	gomaxprocs         []int
+	valueSizes         []int
+	baseline           string
+	threshold          float64
+	opts               Options
+}
+
+func runWithKey[K comparable](cfg config) {
+	switch cfg.valueType {
+	case "int":
+		run[K, int](cfg)
+	case "string":
+		run[K, string](cfg)
+	case "struct{}":
+		run[K, struct{}](cfg)
+	case "struct8":
+		run[K, Struct8](cfg)
+	case "struct64":
+		run[K, Struct64](cfg)
+	case "struct256":
+		run[K, Struct256](cfg)
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported value type: %s\n", cfg.valueType)
+		exit(2)
+	}
+}
+
+// builders returns the constructors of all the map types, which are created
+// with the given size hint.
+//
+// The cocroach and crn4 maps hash keys with seed, the same seed the dataset
+// is generated from. With the "runtime" hasher they use their default hash
+// functions, which build on the runtime hasher; it is keyed randomly at
+// process start, so equal seeds reproduce their layout only within a process.
+// The "xxhash" hasher doesn't depend on the process, so equal seeds reproduce
+// the layout across runs as well. std and dolthub always pick a random hash
+// seed, which can't be overridden.
+func builders[K comparable, V any](seed uint64, size int, hasher string, pow2 bool) map[string]func() Map[K, V] {
+	crn4Hash, cocroachHash := crn4hash.GetHashFunc[K](), crn4hash.GetHashFuncRnt[K]()
+	if hasher == "xxhash" {
+		crn4Hash = crn4hash.GetHashFuncXX[K]()
+		cocroachHash = crn4Hash
+	}
+	return map[string]func() Map[K, V]{
+		"std":             func() Map[K, V] { return NewSimpleMap[K, V](size) },
+		"syncmap":         func() Map[K, V] { return NewSyncMap[K, V]() },
+		"cocroach":        func() Map[K, V] { return NewCocroachMap[K, V](size, cocroachHash, uintptr(seed)) },
+		"crn4":            func() Map[K, V] { return NewCRN4Map[K, V](size, crn4Hash, uintptr(seed), pow2) },
+		"crn4-concurrent": func() Map[K, V] { return NewCRN4ConcurrentMap[K, V](size, crn4Hash, uintptr(seed)) },
+		"dolthub":         func() Map[K, V] { return NewDolthubMap[K, V](size) },
+	}
+}
+
+func run[K, V comparable](cfg config) {
+	var size int
+	if cfg.presize {
+		size = int(cfg.size)
+	}
+	builds := builders[K, V](cfg.seed, size, cfg.opts.Hash, cfg.opts.Pow2Groups)
+	build, ok := builds[cfg.mapType]
+	if !ok {
+		build = builds["std"]
+	}
+	cfg.opts.SizeHint = size
+	b := New[K, V](cfg.size, cfg.seed, build, cfg.opts)
+
+	if cfg.verify {
+		fmt.Println("Verifying Maps")
+		if !Verify(&b, builds) {
+			exit(1)
+		}
+		return
+	}
+
+	procs := cfg.gomaxprocs
+	if len(procs) == 0 {
+		procs = []int{runtime.GOMAXPROCS(0)}
+	}
+
+	// Loaded up front, so that a bad file doesn't waste a whole run.
+	baselines := make(map[int]map[string]float64, len(procs))
+	if cfg.baseline != "" {
+		for _, p := range procs {
+			baseline, err := loadBaseline(cfg.baseline, cfg.mapType, cfg.keyType, cfg.valueType, cfg.size, p)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "loading baseline: %v\n", err)
+				exit(2)
+			}
+			baselines[p] = baseline
+		}
+	}
 
-	b.Run()
+	original := runtime.GOMAXPROCS(0)
+	regressed := false
+	for _, p := range procs {
+		runtime.GOMAXPROCS(p)
+		if len(cfg.gomaxprocs) > 0 {
+			fmt.Printf("Running Map Benchmarks (GOMAXPROCS=%d)\n", p)
+		} else {
+			fmt.Println("Running Map Benchmarks")
+		}
+
+		results := b.Run()
+
+		if cfg.output == "csv" {
+			if err := writeCSV(cfg, results); err != nil {
+				fmt.Fprintf(os.Stderr, "writing csv: %v\n", err)
+				exit(1)
+			}
+		}
+		if cfg.resultsFile != "" {
+			if err := appendResults(cfg, results); err != nil {
+				fmt.Fprintf(os.Stderr, "writing results: %v\n", err)
+				exit(1)
+			}
+		}
+		if baseline, ok := baselines[p]; ok && !compareBaseline(baseline, results, cfg.threshold) {
+			regressed = true
+		}
+	}
+	runtime.GOMAXPROCS(original)
+
+	if regressed {
+		exit(1)
+	}
 }
 
 type SimpleMap[K comparable, V any] struct {
 	data map[K]V
 }
 
-func NewSimpleMap[K comparable, V any]() *SimpleMap[K, V] {
-	return &SimpleMap[K, V]{data: make(map[K]V)}
+func NewSimpleMap[K comparable, V any](size int) *SimpleMap[K, V] {
+	return &SimpleMap[K, V]{data: make(map[K]V, size)}
 }
 
 func (m *SimpleMap[K, V]) Get(key K) (V, bool) {
@@ -51,6 +446,19 @@ func (m *SimpleMap[K, V]) Get(key K) (V, bool) {
 	return value, ok
 }
 
+func (m *SimpleMap[K, V]) Contains(key K) bool {
+	_, ok := m.data[key]
+	return ok
+}
+
+func (m *SimpleMap[K, V]) Iterate(yield func(K, V) bool) {
+	for key, value := range m.data {
+		if !yield(key, value) {
+			return
+		}
+	}
+}
+
 func (m *SimpleMap[K, V]) Set(key K, value V) {
 	m.data[key] = value
 }
@@ -59,12 +467,77 @@ func (m *SimpleMap[K, V]) Delete(key K) {
 	delete(m.data, key)
 }
 
+func (m *SimpleMap[K, V]) Len() int {
+	return len(m.data)
+}
+
+func (m *SimpleMap[K, V]) Clear() {
+	clear(m.data)
+}
+
+// SyncMap adapts sync.Map, the baseline for concurrent reads. It has no size
+// hint, and Len has to range over the whole map.
+type SyncMap[K comparable, V any] struct {
+	data sync.Map
+}
+
+func NewSyncMap[K comparable, V any]() *SyncMap[K, V] {
+	return &SyncMap[K, V]{}
+}
+
+func (m *SyncMap[K, V]) Get(key K) (V, bool) {
+	value, ok := m.data.Load(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return value.(V), true
+}
+
+func (m *SyncMap[K, V]) Contains(key K) bool {
+	_, ok := m.data.Load(key)
+	return ok
+}
+
+func (m *SyncMap[K, V]) Iterate(yield func(K, V) bool) {
+	m.data.Range(func(key, value any) bool {
+		return yield(key.(K), value.(V))
+	})
+}
+
+func (m *SyncMap[K, V]) Set(key K, value V) {
+	m.data.Store(key, value)
+}
+
+func (m *SyncMap[K, V]) Delete(key K) {
+	m.data.Delete(key)
+}
+
+func (m *SyncMap[K, V]) Len() int {
+	n := 0
+	m.data.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func (m *SyncMap[K, V]) Clear() {
+	m.data.Clear()
+}
+
 type Cocroach[K comparable, V any] struct {
 	data *cocroach.Map[K, V]
 }
 
-func NewCocroachMap[K comparable, V any]() *Cocroach[K, V] {
-	return &Cocroach[K, V]{data: cocroach.New[K, V](0)}
+// NewCocroachMap creates a cockroachdb/swiss map that hashes keys with hasher
+// and the given seed, instead of the random seed the map picks on creation
+// and on Clear.
+func NewCocroachMap[K comparable, V any](size int, hasher crn4hash.HFunc, seed uintptr) *Cocroach[K, V] {
+	hash := func(key *K, _ uintptr) uintptr {
+		return hasher(unsafe.Pointer(key), seed)
+	}
+	return &Cocroach[K, V]{data: cocroach.New[K, V](size, cocroach.WithHash[K, V](hash))}
 }
 
 func (m *Cocroach[K, V]) Get(key K) (V, bool) {
@@ -72,6 +545,16 @@ func (m *Cocroach[K, V]) Get(key K) (V, bool) {
 	return value, ok
 }
 
+func (m *Cocroach[K, V]) Contains(key K) bool {
+	// cockroachdb/swiss has no native membership check.
+	_, ok := m.data.Get(key)
+	return ok
+}
+
+func (m *Cocroach[K, V]) Iterate(yield func(K, V) bool) {
+	m.data.All(yield)
+}
+
 func (m *Cocroach[K, V]) Set(key K, value V) {
 	m.data.Put(key, value)
 }
@@ -80,12 +563,23 @@ func (m *Cocroach[K, V]) Delete(key K) {
 	m.data.Delete(key)
 }
 
+func (m *Cocroach[K, V]) Len() int {
+	return m.data.Len()
+}
+
+func (m *Cocroach[K, V]) Clear() {
+	m.data.Clear()
+}
+
 type CRN4[K comparable, V any] struct {
 	data *crn4.Map[K, V]
 }
 
-func NewCRN4Map[K comparable, V any]() *CRN4[K, V] {
-	return &CRN4[K, V]{data: crn4.New[K, V](0)}
+func NewCRN4Map[K comparable, V any](size int, hasher crn4hash.HFunc, seed uintptr, pow2 bool) *CRN4[K, V] {
+	if pow2 {
+		return &CRN4[K, V]{data: crn4.NewWithPow2Groups[K, V](size, hasher, seed)}
+	}
+	return &CRN4[K, V]{data: crn4.NewWithHasher[K, V](size, hasher, seed)}
 }
 
 func (m *CRN4[K, V]) Get(key K) (V, bool) {
@@ -93,6 +587,18 @@ func (m *CRN4[K, V]) Get(key K) (V, bool) {
 	return value, ok
 }
 
+func (m *CRN4[K, V]) Contains(key K) bool {
+	return m.data.Contains(key)
+}
+
+func (m *CRN4[K, V]) Iterate(yield func(K, V) bool) {
+	for key, value := range m.data.All() {
+		if !yield(key, value) {
+			return
+		}
+	}
+}
+
 func (m *CRN4[K, V]) Set(key K, value V) {
 	m.data.Put(key, value)
 }
@@ -101,12 +607,82 @@ func (m *CRN4[K, V]) Delete(key K) {
 	m.data.Delete(key)
 }
 
+func (m *CRN4[K, V]) Len() int {
+	return m.data.Len()
+}
+
+func (m *CRN4[K, V]) Clear() {
+	m.data.Clear()
+}
+
+func (m *CRN4[K, V]) Cap() int {
+	return m.data.Cap()
+}
+
+func (m *CRN4[K, V]) ProbeStats() (float64, int) {
+	return m.data.ProbeStats()
+}
+
+func (m *CRN4[K, V]) ProbeHistogram() []int {
+	return m.data.ProbeHistogram()
+}
+
+func (m *CRN4[K, V]) Rehashes() int {
+	return m.data.Rehashes()
+}
+
+func (m *CRN4[K, V]) Tombstones() int {
+	return m.data.Tombstones()
+}
+
+// CRN4Concurrent adapts the sharded crn4 ConcurrentMap, with GOMAXPROCS
+// shards at the time the map is created.
+type CRN4Concurrent[K comparable, V any] struct {
+	data *crn4.ConcurrentMap[K, V]
+}
+
+func NewCRN4ConcurrentMap[K comparable, V any](size int, hasher crn4hash.HFunc, seed uintptr) *CRN4Concurrent[K, V] {
+	return &CRN4Concurrent[K, V]{data: crn4.NewConcurrentWithHasher[K, V](size, 0, hasher, seed)}
+}
+
+func (m *CRN4Concurrent[K, V]) Get(key K) (V, bool) {
+	return m.data.Get(key)
+}
+
+func (m *CRN4Concurrent[K, V]) Contains(key K) bool {
+	return m.data.Contains(key)
+}
+
+func (m *CRN4Concurrent[K, V]) Iterate(yield func(K, V) bool) {
+	for key, value := range m.data.All() {
+		if !yield(key, value) {
+			return
+		}
+	}
+}
+
+func (m *CRN4Concurrent[K, V]) Set(key K, value V) {
+	m.data.Put(key, value)
+}
+
+func (m *CRN4Concurrent[K, V]) Delete(key K) {
+	m.data.Delete(key)
+}
+
+func (m *CRN4Concurrent[K, V]) Len() int {
+	return m.data.Len()
+}
+
+func (m *CRN4Concurrent[K, V]) Clear() {
+	m.data.Clear()
+}
+
 type Dolthub[K comparable, V any] struct {
 	data *dolthub.Map[K, V]
 }
 
-func NewDolthubMap[K comparable, V any]() *Dolthub[K, V] {
-	return &Dolthub[K, V]{data: dolthub.NewMap[K, V](0)}
+func NewDolthubMap[K comparable, V any](size int) *Dolthub[K, V] {
+	return &Dolthub[K, V]{data: dolthub.NewMap[K, V](uint32(size))}
 }
 
 func (m *Dolthub[K, V]) Get(key K) (V, bool) {
@@ -114,6 +690,16 @@ func (m *Dolthub[K, V]) Get(key K) (V, bool) {
 	return value, ok
 }
 
+func (m *Dolthub[K, V]) Contains(key K) bool {
+	return m.data.Has(key)
+}
+
+func (m *Dolthub[K, V]) Iterate(yield func(K, V) bool) {
+	m.data.Iter(func(key K, value V) bool {
+		return !yield(key, value)
+	})
+}
+
 func (m *Dolthub[K, V]) Set(key K, value V) {
 	m.data.Put(key, value)
 }
@@ -121,3 +707,18 @@ func (m *Dolthub[K, V]) Set(key K, value V) {
 func (m *Dolthub[K, V]) Delete(key K) {
 	m.data.Delete(key)
 }
+
+func (m *Dolthub[K, V]) Len() int {
+	return m.data.Count()
+}
+
+func (m *Dolthub[K, V]) Clear() {
+	m.data.Clear()
+}
+
+// Cap returns the number of entries the map can hold before resizing. The
+// value is exact only while the map has no tombstones, since dolthub/swiss
+// reports neither its limit nor the number of dead slots directly.
+func (m *Dolthub[K, V]) Cap() int {
+	return m.data.Capacity() + m.data.Count()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// crn4Layout fills the crn4 map of the harness with a dataset generated from
+// seed and returns its probe length histogram followed by a dump of its
+// first groups.
+func crn4Layout(seed uint64, hasher string) string {
+	opts := Options{KeyDist: "random", KeyLen: 7, ValueLen: 7, Hash: hasher}
+	bench := New[string, int](10000, seed, builders[string, int](seed, 0, hasher, false)["crn4"], opts)
+	m := bench.fill().(*CRN4[string, int])
+	return fmt.Sprintln(m.ProbeHistogram()) + m.data.String()
+}
+
+// TestXXHashLayoutReproducible checks that equal seeds with the xxhash
+// hasher give the crn4 map the same layout in another process, where the
+// runtime hasher would be keyed differently.
+func TestXXHashLayoutReproducible(t *testing.T) {
+	if os.Getenv("SWISSTABLE_BENCH_LAYOUT") != "" {
+		fmt.Print(crn4Layout(1234, "xxhash"))
+		os.Exit(0)
+	}
+	want := crn4Layout(1234, "xxhash")
+	if got := crn4Layout(1234, "xxhash"); got != want {
+		t.Fatalf("layout differs within the process:\n%s\nwant:\n%s", got, want)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestXXHashLayoutReproducible$")
+	cmd.Env = append(os.Environ(), "SWISSTABLE_BENCH_LAYOUT=1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, []byte(want)) {
+		t.Errorf("layout differs in another process:\n%s\nwant:\n%s", out, want)
+	}
+}
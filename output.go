@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// csvHeader returns the columns of the csv output: the benchmark parameters
+// followed by ns/op, allocs/op and bytes/op of every phase.
+func csvHeader() []string {
+	header := []string{
+		"map_type", "key_type", "value_type", "size", "seed", "presize",
+		"distribution", "zipf_s", "key_dist", "hit_ratio", "gomaxprocs", "hash",
+		"dup_ratio", "key_len", "value_len", "pow2_groups",
+	}
+	for _, p := range Phases {
+		header = append(header, p+"_ns_op", p+"_allocs_op", p+"_bytes_op")
+	}
+	return header
+}
+
+// csvRow returns the csv record of a single run. The columns of phases that
+// didn't run are left empty, so that rows of different runs line up.
+func csvRow(cfg config, results []Result) []string {
+	row := []string{
+		cfg.mapType, cfg.keyType, cfg.valueType,
+		strconv.FormatUint(cfg.size, 10),
+		strconv.FormatUint(cfg.seed, 10),
+		strconv.FormatBool(cfg.presize),
+		cfg.opts.Distribution,
+		strconv.FormatFloat(cfg.opts.ZipfS, 'g', -1, 64),
+		cfg.opts.KeyDist,
+		strconv.FormatFloat(cfg.opts.HitRatio, 'g', -1, 64),
+		strconv.Itoa(runtime.GOMAXPROCS(0)),
+		cfg.opts.Hash,
+		strconv.FormatFloat(cfg.opts.DupRatio, 'g', -1, 64),
+		strconv.Itoa(cfg.opts.KeyLen),
+		strconv.Itoa(cfg.opts.ValueLen),
+		strconv.FormatBool(cfg.opts.Pow2Groups),
+	}
+	byPhase := make(map[string]Result, len(results))
+	for _, r := range results {
+		byPhase[r.Phase] = r
+	}
+	for _, p := range Phases {
+		r, ok := byPhase[p]
+		if !ok {
+			row = append(row, "", "", "")
+			continue
+		}
+		row = append(row,
+			strconv.FormatInt(r.NsPerOp(), 10),
+			strconv.FormatInt(r.AllocsPerOp(), 10),
+			strconv.FormatInt(r.AllocedBytesPerOp(), 10),
+		)
+	}
+	return row
+}
+
+// writeCSV appends the csv record of the run to cfg.csvFile, or writes it to
+// stdout if no file is set. The header is written first when the output is
+// stdout or the file is new, so the file can accumulate the rows of many
+// invocations.
+func writeCSV(cfg config, results []Result) error {
+	var (
+		w         io.Writer = os.Stdout
+		newOutput           = true
+	)
+	if cfg.csvFile != "" {
+		f, err := os.OpenFile(cfg.csvFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		w, newOutput = f, fi.Size() == 0
+	}
+
+	cw := csv.NewWriter(w)
+	if newOutput {
+		if err := cw.Write(csvHeader()); err != nil {
+			return err
+		}
+	}
+	if err := cw.Write(csvRow(cfg, results)); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// resultRecord is a single line of the results file.
+type resultRecord struct {
+	Time      time.Time          `json:"time"`
+	Version   string             `json:"version,omitempty"`
+	MapType   string             `json:"map_type"`
+	KeyType   string             `json:"key_type,omitempty"`
+	ValueType string             `json:"value_type,omitempty"`
+	Size      uint64             `json:"size"`
+	Seed      uint64             `json:"seed"`
+	Procs     int                `json:"gomaxprocs,omitempty"`
+	NsPerOp   map[string]float64 `json:"ns_per_op"`
+}
+
+// version returns the module version of the binary, or the VCS revision it
+// was built from, suffixed with "-dirty" for a modified tree, when the module
+// version is unknown. It is empty if the build info has neither.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	var rev, dirty string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				dirty = "-dirty"
+			}
+		}
+	}
+	if rev == "" {
+		return ""
+	}
+	return rev[:min(len(rev), 12)] + dirty
+}
+
+// appendResults appends the results of the run to cfg.resultsFile as a single
+// JSON object per line, so the file accumulates the history of many runs.
+func appendResults(cfg config, results []Result) error {
+	rec := resultRecord{
+		Time:      time.Now().UTC(),
+		Version:   version(),
+		MapType:   cfg.mapType,
+		KeyType:   cfg.keyType,
+		ValueType: cfg.valueType,
+		Size:      cfg.size,
+		Seed:      cfg.seed,
+		Procs:     runtime.GOMAXPROCS(0),
+		NsPerOp:   make(map[string]float64, len(results)),
+	}
+	for _, r := range results {
+		rec.NsPerOp[r.Phase] = nsPerOp(r.BenchmarkResult)
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(cfg.resultsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
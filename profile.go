@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// stopProfiles stops the profiles started by startProfiles. It is a no-op
+// until then, so that exit paths can call it unconditionally.
+var stopProfiles = func() {}
+
+// startProfiles starts writing a CPU profile to cpuFile and arranges for
+// stopProfiles to stop it and to write a heap profile to memFile. Either
+// path may be empty to skip that profile. The heap profile is written once
+// all the benchmarks are done and their maps are garbage, so its allocation
+// samples, pprof -sample_index=alloc_space, are the interesting part.
+func startProfiles(cpuFile, memFile string) error {
+	var cpu *os.File
+	if cpuFile != "" {
+		f, err := os.Create(cpuFile)
+		if err != nil {
+			return err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return err
+		}
+		cpu = f
+	}
+	stopProfiles = func() {
+		stopProfiles = func() {}
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "writing cpu profile: %v\n", err)
+			}
+		}
+		if memFile != "" {
+			if err := writeHeapProfile(memFile); err != nil {
+				fmt.Fprintf(os.Stderr, "writing memory profile: %v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Brings the profile up to date with the last allocations.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Spread summarizes the ns/op of the repeated runs of a phase. It is the zero
+// value for a phase that ran once.
+type Spread struct {
+	Runs             int
+	Min, Median, Max float64
+	// CV is the coefficient of variation of ns/op, the standard deviation
+	// divided by the mean.
+	CV float64
+}
+
+func (s Spread) String() string {
+	if s.Runs < 2 {
+		return ""
+	}
+	return fmt.Sprintf(", runs = %d: min = %.2f, median = %.2f, max = %.2f ns/op, cv = %.2f%%",
+		s.Runs, s.Min, s.Median, s.Max, s.CV*100)
+}
+
+// repeat calls f Options.Runs times and returns the run with the median ns/op
+// together with the spread of all the runs. For an even number of runs the
+// median is the mean of the two middle runs, see midpoint.
+func (bench *Bench[K, V]) repeat(phase string, f func() testing.BenchmarkResult) Result {
+	runs := make([]testing.BenchmarkResult, max(bench.opts.Runs, 1))
+	for i := range runs {
+		runs[i] = f()
+	}
+	if len(runs) == 1 {
+		return Result{Phase: phase, BenchmarkResult: runs[0]}
+	}
+
+	slices.SortFunc(runs, func(a, b testing.BenchmarkResult) int {
+		return cmp.Compare(nsPerOp(a), nsPerOp(b))
+	})
+	var sum, sqsum float64
+	for _, r := range runs {
+		ns := nsPerOp(r)
+		sum += ns
+		sqsum += ns * ns
+	}
+	n := float64(len(runs))
+	mean := sum / n
+	var cv float64
+	if mean > 0 {
+		cv = math.Sqrt(max(sqsum/n-mean*mean, 0)) / mean
+	}
+	median := runs[len(runs)/2]
+	if len(runs)%2 == 0 {
+		median = midpoint(runs[len(runs)/2-1], median)
+	}
+	return Result{
+		Phase:           phase,
+		BenchmarkResult: median,
+		Spread: Spread{
+			Runs:   len(runs),
+			Min:    nsPerOp(runs[0]),
+			Median: nsPerOp(median),
+			Max:    nsPerOp(runs[len(runs)-1]),
+			CV:     cv,
+		},
+	}
+}
+
+// measure runs the benchmark function f like testing.Benchmark, repeating it
+// Options.Runs times.
+func (bench *Bench[K, V]) measure(phase string, f func(b *testing.B)) Result {
+	return bench.repeat(phase, func() testing.BenchmarkResult {
+		return testing.Benchmark(f)
+	})
+}
+
+// midpoint returns a with its time and memory statistics replaced by the
+// mean of the per-op statistics of a and b, scaled back to a.N iterations.
+func midpoint(a, b testing.BenchmarkResult) testing.BenchmarkResult {
+	n := float64(a.N)
+	perOp := func(total uint64, r testing.BenchmarkResult) float64 {
+		if r.N <= 0 {
+			return 0
+		}
+		return float64(total) / float64(r.N)
+	}
+	r := a
+	r.T = time.Duration((nsPerOp(a) + nsPerOp(b)) / 2 * n)
+	r.MemAllocs = uint64((perOp(a.MemAllocs, a) + perOp(b.MemAllocs, b)) / 2 * n)
+	r.MemBytes = uint64((perOp(a.MemBytes, a) + perOp(b.MemBytes, b)) / 2 * n)
+	return r
+}
+
+// nsPerOp is like testing.BenchmarkResult.NsPerOp, without rounding to whole
+// nanoseconds, which would hide the differences between fast operations.
+func nsPerOp(r testing.BenchmarkResult) float64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return float64(r.T.Nanoseconds()) / float64(r.N)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRepeatMedian(t *testing.T) {
+	tests := []struct {
+		ns     []time.Duration
+		median float64
+	}{
+		{[]time.Duration{30, 10, 20}, 20},
+		{[]time.Duration{40, 10, 30, 20}, 25},
+		{[]time.Duration{10, 20}, 15},
+	}
+	for _, tt := range tests {
+		bench := &Bench[int, int]{opts: Options{Runs: len(tt.ns)}}
+		i := 0
+		r := bench.repeat("test", func() testing.BenchmarkResult {
+			res := testing.BenchmarkResult{N: 100, T: tt.ns[i] * 100, MemAllocs: uint64(tt.ns[i]) * 100}
+			i++
+			return res
+		})
+		if r.Spread.Median != tt.median {
+			t.Errorf("runs %v: Spread.Median = %v; want %v", tt.ns, r.Spread.Median, tt.median)
+		}
+		if got := nsPerOp(r.BenchmarkResult); got != tt.median {
+			t.Errorf("runs %v: median result has %v ns/op; want %v", tt.ns, got, tt.median)
+		}
+		if got := r.AllocsPerOp(); float64(got) != tt.median {
+			t.Errorf("runs %v: median result has %d allocs/op; want %v", tt.ns, got, tt.median)
+		}
+	}
+}
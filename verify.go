@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Verify runs every map built by builds through the same sequence of
+// operations and compares the observed results with the std map, which is
+// used as the oracle. It inserts all the keys, looks them up, looks them up
+// again through copies whose strings are freshly allocated, deletes every
+// other key, looks them up again and finally iterates over the whole map,
+// checking Len against the oracle after the insertions and the deletions.
+// The copies catch hash functions that hash string headers instead of
+// contents, which the oracle can't, as it is given the very same keys.
+// NaN keys, which nothing can look up, are counted while iterating instead:
+// every one inserted must be visited.
+// Divergences are reported with the offending key. Verify reports whether
+// all the maps behaved like the oracle.
+func Verify[K, V comparable](bench *Bench[K, V], builds map[string]func() Map[K, V]) bool {
+	names := make([]string, 0, len(builds))
+	for name := range builds {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	ok := true
+	for _, name := range names {
+		survivors, err := verify(bench, builds[name]())
+		if err != nil {
+			fmt.Printf("%s: FAIL: %v\n", name, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("%s: ok, %d entries left after delete\n", name, survivors)
+	}
+	return ok
+}
+
+// verify returns the number of entries left in m after the deletions.
+func verify[K, V comparable](bench *Bench[K, V], m Map[K, V]) (int, error) {
+	oracle := NewSimpleMap[K, V](0)
+	for i, key := range bench.keys {
+		m.Set(key, bench.values[i])
+		oracle.Set(key, bench.values[i])
+	}
+	if err := lookupAll(bench.keys, m, oracle, "after insert"); err != nil {
+		return 0, err
+	}
+	fresh := make([]K, len(bench.keys))
+	for i, key := range bench.keys {
+		fresh[i] = cloneKey(key)
+	}
+	if err := lookupAll(fresh, m, oracle, "fresh keys after insert"); err != nil {
+		return 0, err
+	}
+	if m.Len() != oracle.Len() {
+		return 0, fmt.Errorf("after insert: Len() = %d; want %d", m.Len(), oracle.Len())
+	}
+
+	for i := 0; i < len(bench.keys); i += 2 {
+		m.Delete(bench.keys[i])
+		oracle.Delete(bench.keys[i])
+	}
+	if err := lookupAll(bench.keys, m, oracle, "after delete"); err != nil {
+		return 0, err
+	}
+	if m.Len() != oracle.Len() {
+		return 0, fmt.Errorf("after delete: Len() = %d; want %d", m.Len(), oracle.Len())
+	}
+
+	wantNaNs := 0
+	for _, key := range bench.keys {
+		// Only NaN keys, or keys holding NaNs, differ from themselves.
+		if key != key {
+			wantNaNs++
+		}
+	}
+	seen := make(map[K]V, len(oracle.data))
+	nans := 0
+	var iterErr error
+	m.Iterate(func(key K, value V) bool {
+		if key != key {
+			nans++
+			seen[key] = value
+			return true
+		}
+		if _, dup := seen[key]; dup {
+			iterErr = fmt.Errorf("iterate: key %v visited twice", key)
+			return false
+		}
+		seen[key] = value
+		want, ok := oracle.Get(key)
+		if !ok {
+			iterErr = fmt.Errorf("iterate: unexpected key %v", key)
+			return false
+		}
+		if value != want {
+			iterErr = fmt.Errorf("iterate: key %v: got %v, want %v", key, value, want)
+			return false
+		}
+		return true
+	})
+	if iterErr != nil {
+		return 0, iterErr
+	}
+	if nans != wantNaNs {
+		return 0, fmt.Errorf("iterate: visited %d NaN keys, want %d", nans, wantNaNs)
+	}
+	if len(seen) != len(oracle.data) {
+		return 0, fmt.Errorf("iterate: visited %d keys, want %d", len(seen), len(oracle.data))
+	}
+	return len(seen), nil
+}
+
+func lookupAll[K, V comparable](keys []K, m, oracle Map[K, V], stage string) error {
+	for _, key := range keys {
+		got, gotOK := m.Get(key)
+		want, wantOK := oracle.Get(key)
+		if gotOK != wantOK || got != want {
+			return fmt.Errorf("%s: Get(%v) = %v, %t; want %v, %t", stage, key, got, gotOK, want, wantOK)
+		}
+		if m.Contains(key) != wantOK {
+			return fmt.Errorf("%s: Contains(%v) = %t; want %t", stage, key, !wantOK, wantOK)
+		}
+	}
+	return nil
+}
+
+// cloneKey returns a copy of key in which every string, including the
+// strings of struct fields and array elements, is reallocated. Pointers are
+// kept, since they are compared by address.
+func cloneKey[K any](key K) K {
+	cloneStrings(reflect.ValueOf(&key).Elem())
+	return key
+}
+
+func cloneStrings(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(strings.Clone(v.String()))
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if f := v.Field(i); f.CanSet() {
+				cloneStrings(f)
+			}
+		}
+	case reflect.Array:
+		for i := range v.Len() {
+			cloneStrings(v.Index(i))
+		}
+	}
+}